@@ -0,0 +1,145 @@
+// This is free and unencumbered software released into the public domain.
+//
+// Anyone is free to copy, modify, publish, use, compile, sell, or
+// distribute this software, either in source code form or as a compiled
+// binary, for any purpose, commercial or non-commercial, and by any
+// means.
+//
+// In jurisdictions that recognize copyright laws, the author or authors
+// of this software dedicate any and all copyright interest in the
+// software to the public domain. We make this dedication for the benefit
+// of the public at large and to the detriment of our heirs and
+// successors. We intend this dedication to be an overt act of
+// relinquishment in perpetuity of all present and future rights to this
+// software under copyright law.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// For more information, please refer to <http://unlicense.org>
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+const configName = ".dtex.toml"
+
+// ToolConfig registers an auxiliary tool (biber, makeglossaries,
+// makeindex, ...) that should run against tmpbase whenever its Trigger
+// pattern matches a line in the TeX log.
+type ToolConfig struct {
+	Name    string `toml:"name"`
+	Trigger string `toml:"trigger"`
+}
+
+// Config is the shape of a project's .dtex.toml.
+type Config struct {
+	Engine        string       `toml:"engine"`
+	MaxIterations int          `toml:"max_iterations"`
+	ExtraArgs     []string     `toml:"extra_args"`
+	PreHooks      []string     `toml:"pre_hooks"`
+	PostHooks     []string     `toml:"post_hooks"`
+	Tools         []ToolConfig `toml:"tool"`
+}
+
+func defaultConfig() *Config {
+	return &Config{MaxIterations: 5}
+}
+
+// FindConfig searches from dir upward to the filesystem root for a
+// .dtex.toml project file, returning its path, or "" if none was found.
+func FindConfig(dir string) string {
+	for {
+		path := filepath.Join(dir, configName)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig loads and parses the .dtex.toml found by searching upward from
+// the directory of file, registering any [[tool]] entries it declares. It
+// returns a Config with sane defaults and no path if none was found.
+func LoadConfig(file string) *Config {
+	cfg := defaultConfig()
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		log.Printf("absolute path(%q): %v\n", file, err)
+		return cfg
+	}
+	path := FindConfig(dir)
+	if path == "" {
+		return cfg
+	}
+	log.Println("Using config:", path)
+	if _, err := toml.DecodeFile(path, cfg); err != nil {
+		log.Println("Parse", path, ":", err)
+		return defaultConfig()
+	}
+	if cfg.MaxIterations <= 0 {
+		cfg.MaxIterations = 5
+	}
+	for _, t := range cfg.Tools {
+		if err := RegisterTool(t.Name, t.Trigger); err != nil {
+			log.Println(err)
+		}
+	}
+	return cfg
+}
+
+// RunHooks runs each hook as a shell command, in order, stopping at the
+// first failure.
+func RunHooks(hooks []string) error {
+	for _, hook := range hooks {
+		log.Println("Running hook:", hook)
+		out, err := exec.Command("sh", "-c", hook).CombinedOutput()
+		if err != nil {
+			os.Stdout.Write(out)
+			return fmt.Errorf("hook %q: %v", hook, err)
+		}
+	}
+	return nil
+}
+
+const starterConfig = `# dtex project configuration. Every key is optional; CLI flags still
+# override whatever is set here.
+
+# engine = "pdflatex"
+# max_iterations = 5
+# extra_args = ["-shell-escape", "-synctex=1"]
+# pre_hooks = []
+# post_hooks = []
+
+# [[tool]]
+# name = "biber"
+# trigger = 'Please \(re\)run Biber'
+`
+
+// InitConfig writes a starter .dtex.toml in dir, refusing to overwrite one
+// that already exists.
+func InitConfig(dir string) error {
+	path := filepath.Join(dir, configName)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+	return ioutil.WriteFile(path, []byte(starterConfig), 0644)
+}
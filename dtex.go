@@ -27,14 +27,19 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
-	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
 )
 
 func Usage() {
@@ -46,34 +51,155 @@ func Usage() {
 	fmt.Fprintln(os.Stderr, "Usage: dtex -clean")
 	fmt.Fprintln(os.Stderr, "")
 	fmt.Fprintln(os.Stderr, "will remove all temporary files used by this program.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Usage: dtex -init [dir]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "will write a starter .dtex.toml in dir (default: the current directory).")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Pass -watch to keep rebuilding whenever a source file changes.")
+	fmt.Fprintln(os.Stderr, "Pass -report <path> to write a JSON build report to path.")
 	os.Exit(1)
 }
 
+// TakeWatchFlag reports whether "-watch" is present in args and returns the
+// remaining args with it removed, since it isn't a flag the TeX engine
+// understands.
+func TakeWatchFlag(args []string) (bool, []string) {
+	watch := false
+	rest := make([]string, 0, len(args))
+	for _, a := range args {
+		if a == "-watch" {
+			watch = true
+			continue
+		}
+		rest = append(rest, a)
+	}
+	return watch, rest
+}
+
+// TakeReportFlag extracts "-report <path>" from args, if present, and
+// returns the remaining args with both tokens removed, since neither is a
+// flag the TeX engine understands.
+func TakeReportFlag(args []string) (string, []string) {
+	var path string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-report" && i+1 < len(args) {
+			path = args[i+1]
+			i++
+			continue
+		}
+		rest = append(rest, args[i])
+	}
+	return path, rest
+}
+
 // root directory where the TEX engine writes temporary files (.aux, ...)
 var tmp = filepath.Join(os.TempDir(), "dtex")
 
 func main() {
 	SetLogOutput()
 	log.Println("Using temporary root:", tmp)
-	args := os.Args[1:]
-	file := ParseArgs(args)
+	rawArgs := os.Args[1:]
+	watch, rawArgs := TakeWatchFlag(rawArgs)
+	reportPath, rawArgs := TakeReportFlag(rawArgs)
+	file := ParseArgs(rawArgs)
+	cfg := LoadConfig(file)
 	tmpbase := GetTmp(file)
-	args = append([]string{"-output-directory", filepath.Dir(tmpbase)}, args...)
-	tex := GetTexEngine()
+	args := append([]string{"-output-directory", filepath.Dir(tmpbase)}, cfg.ExtraArgs...)
+	args = append(args, rawArgs...)
+	tex := GetTexEngine(cfg)
 
+	if err := RunHooks(cfg.PreHooks); err != nil {
+		Err("%v\n", err)
+	}
+
+	var report *Report
+	if reportPath != "" {
+		report = NewReport(tex)
+	}
+
+	cache := CachePath(tmpbase)
 	log.Println("Computing initial hashes of", tmpbase)
 	hashes := NewHashes(tmpbase)
-	for try := 0; hashes.Changed() && try < 5; try++ {
+	// Only force the first compile when there's genuinely nothing cached to
+	// converge from yet (a brand-new project); otherwise trust hashes.Changed(),
+	// so a project whose persisted digests already match disk can skip
+	// compiling entirely.
+	RunConvergence(tex, args, tmpbase, cfg, hashes, report, hashes.Empty())
+	if err := hashes.Save(cache); err != nil {
+		log.Println("Save hash cache:", err)
+	}
+	if err := os.Rename(tmpbase+".pdf", file+".pdf"); err != nil {
+		Err("Move resulting pdf into place: %v\n", err)
+	}
+
+	if report != nil {
+		if err := report.CountLogIssues(tmpbase + ".log"); err != nil {
+			log.Println("Count log issues:", err)
+		}
+		report.Finish(file + ".pdf")
+		if err := report.Save(reportPath); err != nil {
+			log.Println("Save report:", err)
+		}
+	}
+
+	if watch {
+		Watch(filepath.Dir(file), file, tmpbase, tex, args, cfg, hashes, cache)
+	}
+
+	if err := RunHooks(cfg.PostHooks); err != nil {
+		Err("%v\n", err)
+	}
+}
+
+// RunConvergence compiles with tex/args until hashes.Update finds nothing
+// changed and ScanLog finds no rerun signals left in the .log, invoking
+// whatever auxiliary tool (biber, bibtex, makeindex) a signal implicates
+// before the next pass, up to cfg.MaxIterations times. If report is
+// non-nil, each iteration's timing and changed files are recorded into it.
+// force makes the first iteration run unconditionally, regardless of
+// hashes' leftover convergence state: a fresh project has nothing to hash
+// yet, and an externally-triggered rebuild (e.g. -watch) has a source
+// change that Hashes, which only tracks the output directory, can't see.
+func RunConvergence(tex string, args []string, tmpbase string, cfg *Config, hashes *Hashes, report *Report, force bool) {
+	var signals []RerunSignal
+	for try := 0; (force || hashes.Changed() || len(signals) > 0) && try < cfg.MaxIterations; try++ {
+		force = false
 		log.Println("Compile iteration", try)
+		start := time.Now()
 		Compile(tex, args)
 		log.Println("Updating hashes")
 		hashes.Update()
+
+		var err error
+		signals, err = ScanLog(tmpbase + ".log")
+		if err != nil {
+			log.Println("Scan log:", err)
+			signals = nil
+		}
+		for _, s := range signals {
+			log.Println("Rerun signal:", s.Reason)
+		}
+		tools := ToolsNeeded(signals)
+		for _, tool := range tools {
+			log.Println("Running", tool, "on", tmpbase)
+			if err := RunAuxTool(tool, tmpbase); err != nil {
+				log.Println(err)
+			}
+		}
+		if report != nil {
+			report.AddIteration(IterationReport{
+				Number:   try,
+				Duration: time.Since(start).String(),
+				Changed:  hashes.LastChanged(),
+				Signals:  signals,
+				Tools:    tools,
+			})
+		}
 	}
-	if hashes.Changed() {
-		fmt.Fprintln(os.Stderr, "Warning: 5 compilations were maybe insufficient")
-	}
-	if err := os.Rename(tmpbase+".pdf", file+".pdf"); err != nil {
-		Err("Move resulting pdf into place: %v\n", err)
+	if hashes.Changed() || len(signals) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d compilations were maybe insufficient\n", cfg.MaxIterations)
 	}
 }
 
@@ -99,6 +225,19 @@ func ParseArgs(args []string) string {
 		}
 		os.Exit(0)
 	}
+	if len(args) >= 1 && args[0] == "-init" {
+		dir := "."
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		if len(args) > 2 {
+			Usage()
+		}
+		if err := InitConfig(dir); err != nil {
+			Err("dtex -init: %v\n", err)
+		}
+		os.Exit(0)
+	}
 	if len(args) < 1 {
 		Usage()
 	}
@@ -130,8 +269,13 @@ func GetTmp(file string) string {
 	return tmpbase
 }
 
-func GetTexEngine() string {
+// GetTexEngine picks the TeX engine to run: cfg.Engine if the project's
+// .dtex.toml sets one, overridden by $TEX if that's set too.
+func GetTexEngine(cfg *Config) string {
 	tex := "pdflatex"
+	if cfg.Engine != "" {
+		tex = cfg.Engine
+	}
 	if t := os.Getenv("TEX"); t != "" {
 		tex = t
 	}
@@ -139,44 +283,152 @@ func GetTexEngine() string {
 }
 
 type Hashes struct {
-	base string
-	h    map[string]uint64
-	mod  bool
+	base        string
+	h           map[string]string
+	mod         bool
+	lastChanged map[string]string // file -> new digest, from the most recent Update
+}
+
+// CachePath returns the path of the JSON file used to persist a document's
+// digests across dtex invocations.
+func CachePath(base string) string {
+	return base + ".hashes.json"
 }
 
+// NewHashes loads whatever digests were persisted by a previous invocation
+// (if any) and immediately compares them against the files on disk, so a
+// cold `dtex` run that finds nothing changed can skip the first compile.
 func NewHashes(base string) *Hashes {
-	h := &Hashes{base: base, h: map[string]uint64{}}
+	h := &Hashes{base: base, h: map[string]string{}}
+	if err := h.Load(CachePath(base)); err != nil {
+		log.Println("No cached hashes:", err)
+	}
 	h.Update()
-	h.mod = true
 	return h
 }
 
+// Load reads a previously Save'd digest map from path into h, replacing
+// whatever it held.
+func (h *Hashes) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &h.h)
+}
+
+// Save writes h's digest map to path as JSON, so the next invocation can
+// pick up where this one left off.
+func (h *Hashes) Save(path string) error {
+	data, err := json.MarshalIndent(h.h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// hashWorkers returns how many goroutines Update should use to hash files
+// in parallel, capped so a huge machine doesn't spawn hundreds of workers
+// for a few dozen aux files.
+func hashWorkers() int {
+	n := runtime.NumCPU()
+	if n > 8 {
+		n = 8
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+type hashResult struct {
+	file   string // absolute path, for opening/logging
+	rel    string // base name, used as the persisted cache key
+	digest string
+}
+
+// Update re-hashes every aux file next to h.base, fanning the work out over
+// a bounded worker pool so large documents (biblatex + makeindex + minted +
+// many chapters) don't pay for hashing serially between compile iterations.
 func (h *Hashes) Update() {
 	pat := filepath.Join(filepath.Dir(h.base), "*.*")
 	files, err := filepath.Glob(pat)
 	if err != nil {
 		Err("bad filepath.Glob(%q): %v\n", pat, err)
 	}
+
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, file := range files {
+			ext := filepath.Ext(file)
+			if ext == ".pdf" || ext == ".log" || ext == ".json" {
+				continue
+			}
+			jobs <- file
+		}
+	}()
+
+	results := make(chan hashResult)
+	var workers sync.WaitGroup
+	n := hashWorkers()
+	workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer workers.Done()
+			for file := range jobs {
+				results <- hashResult{file: file, rel: filepath.Base(file), digest: HashFile(file)}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	// The collector below is the sole consumer of results, so no lock is
+	// needed around h.h/collected/changed.
+	var collected []hashResult
 	h.mod = false
-	for _, file := range files {
-		ext := filepath.Ext(file)
-		if ext == ".pdf" || ext == ".log" {
-			continue
+	changed := map[string]string{}
+	for r := range results {
+		if r.digest != h.h[r.rel] {
+			h.mod = true
+			changed[r.rel] = r.digest
 		}
-		id := HashFile(file)
-		log.Println("Hashing", file, "→", id)
-		if id != h.h[file] {
+		h.h[r.rel] = r.digest
+		collected = append(collected, r)
+	}
+	h.lastChanged = changed
+
+	// Hashing completes out of order; sort before logging so -VERBOSE
+	// output stays deterministic between runs.
+	sort.Slice(collected, func(i, j int) bool { return collected[i].file < collected[j].file })
+	for _, r := range collected {
+		log.Println("Hashing", r.file, "→", r.digest)
+		if _, ok := changed[r.rel]; ok {
 			log.Println("  file changed")
-			h.mod = true
 		}
-		h.h[file] = id
 	}
 }
 
+// Empty reports whether h holds no digests at all, which is only the case
+// on a brand-new project where the aux directory has never been populated:
+// there is nothing yet to compare a compile's output against.
+func (h *Hashes) Empty() bool { return len(h.h) == 0 }
+
+// LastChanged returns the files (and their new digests) that changed
+// during the most recent Update call.
+func (h *Hashes) LastChanged() map[string]string { return h.lastChanged }
+
 func (h *Hashes) Changed() bool { return h.mod }
 
-func HashFile(file string) uint64 {
-	h := fnv.New64a()
+// HashFile returns a content-addressable digest of file, in the
+// "sha256:<hex>" form used by content-addressable checksum systems, so
+// convergence between compile iterations is never confused by a hash
+// collision.
+func HashFile(file string) string {
+	h := sha256.New()
 	f, err := os.Open(file)
 	if err != nil {
 		Err("Open file (%v): %v\n", file, err)
@@ -185,7 +437,7 @@ func HashFile(file string) uint64 {
 	if _, err := io.Copy(h, f); err != nil {
 		Err("Read file (%v): %v\n", file, err)
 	}
-	return h.Sum64()
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
 }
 
 func Compile(tex string, args []string) {
@@ -0,0 +1,131 @@
+// This is free and unencumbered software released into the public domain.
+//
+// Anyone is free to copy, modify, publish, use, compile, sell, or
+// distribute this software, either in source code form or as a compiled
+// binary, for any purpose, commercial or non-commercial, and by any
+// means.
+//
+// In jurisdictions that recognize copyright laws, the author or authors
+// of this software dedicate any and all copyright interest in the
+// software to the public domain. We make this dedication for the benefit
+// of the public at large and to the detriment of our heirs and
+// successors. We intend this dedication to be an overt act of
+// relinquishment in perpetuity of all present and future rights to this
+// software under copyright law.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// For more information, please refer to <http://unlicense.org>
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// RerunSignal is one reason, found in a TeX engine's .log file, that
+// another compile iteration (and possibly an auxiliary tool) is needed.
+type RerunSignal struct {
+	Reason string
+	Tool   string // "" if no auxiliary tool is implicated, else "biber", "bibtex" or "makeindex"
+}
+
+var rerunPatterns = []struct {
+	re     *regexp.Regexp
+	reason string
+	tool   string
+}{
+	{regexp.MustCompile(`Rerun to get cross-references right`), "cross-references may have changed", ""},
+	{regexp.MustCompile(`Label\(s\) may have changed`), "labels may have changed", ""},
+	{regexp.MustCompile(`Package rerunfilecheck Warning`), "rerunfilecheck requested a rerun", ""},
+	{regexp.MustCompile(`Please \(re\)run Biber`), "bibliography is stale", "biber"},
+	{regexp.MustCompile(`No file .*\.bbl`), "bibliography is missing", "bibtex"},
+	{regexp.MustCompile(`No file .*\.ind`), "index is missing", "makeindex"},
+	{regexp.MustCompile(`Rerun to get (index|table of contents) right`), "index or toc is stale", "makeindex"},
+}
+
+// ScanLog reads the TeX engine's .log file at path and returns every reason
+// it claims a rerun is needed, in the order they were seen, deduplicated by
+// (reason, tool).
+func ScanLog(path string) ([]RerunSignal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var signals []RerunSignal
+	seen := map[RerunSignal]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, p := range rerunPatterns {
+			if !p.re.MatchString(line) {
+				continue
+			}
+			s := RerunSignal{Reason: p.reason, Tool: p.tool}
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+			signals = append(signals, s)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return signals, err
+	}
+	return signals, nil
+}
+
+// ToolsNeeded returns the set of auxiliary tools (biber, bibtex, makeindex)
+// implicated by signals, in first-seen order.
+func ToolsNeeded(signals []RerunSignal) []string {
+	seen := map[string]bool{}
+	var tools []string
+	for _, s := range signals {
+		if s.Tool == "" || seen[s.Tool] {
+			continue
+		}
+		seen[s.Tool] = true
+		tools = append(tools, s.Tool)
+	}
+	return tools
+}
+
+// RegisterTool adds a custom aux-tool trigger, as configured by a project's
+// .dtex.toml [[tool]] entries, so ScanLog also fires for tools dtex doesn't
+// know about out of the box.
+func RegisterTool(tool, trigger string) error {
+	re, err := regexp.Compile(trigger)
+	if err != nil {
+		return fmt.Errorf("tool %q: %v", tool, err)
+	}
+	rerunPatterns = append(rerunPatterns, struct {
+		re     *regexp.Regexp
+		reason string
+		tool   string
+	}{re, fmt.Sprintf("%s trigger matched", tool), tool})
+	return nil
+}
+
+// RunAuxTool invokes tool (biber, bibtex or makeindex) against tmpbase, the
+// same basename pdflatex was given via -output-directory, so it picks up
+// that run's .bcf/.aux/.idx.
+func RunAuxTool(tool, tmpbase string) error {
+	out, err := exec.Command(tool, tmpbase).CombinedOutput()
+	if err != nil {
+		os.Stdout.Write(out)
+		return fmt.Errorf("%s %s: %v", tool, tmpbase, err)
+	}
+	return nil
+}
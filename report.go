@@ -0,0 +1,106 @@
+// This is free and unencumbered software released into the public domain.
+//
+// Anyone is free to copy, modify, publish, use, compile, sell, or
+// distribute this software, either in source code form or as a compiled
+// binary, for any purpose, commercial or non-commercial, and by any
+// means.
+//
+// In jurisdictions that recognize copyright laws, the author or authors
+// of this software dedicate any and all copyright interest in the
+// software to the public domain. We make this dedication for the benefit
+// of the public at large and to the detriment of our heirs and
+// successors. We intend this dedication to be an overt act of
+// relinquishment in perpetuity of all present and future rights to this
+// software under copyright law.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// For more information, please refer to <http://unlicense.org>
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// IterationReport summarizes one pass through the compile loop, so editor
+// integrations and CI can see what changed and why a rerun happened.
+type IterationReport struct {
+	Number   int               `json:"number"`
+	Duration string            `json:"duration"`
+	Changed  map[string]string `json:"changed,omitempty"` // file -> new digest
+	Signals  []RerunSignal     `json:"signals,omitempty"`
+	Tools    []string          `json:"tools,omitempty"`
+}
+
+// Report is the JSON document written by -report, modeled on the build
+// reports of other content-addressable build tools: enough detail that an
+// editor or CI system can consume it instead of scraping stderr.
+type Report struct {
+	Engine     string            `json:"engine"`
+	Iterations []IterationReport `json:"iterations"`
+	Warnings   int               `json:"warnings"`
+	Errors     int               `json:"errors"`
+	PDFPath    string            `json:"pdf_path"`
+	PDFSize    int64             `json:"pdf_size"`
+}
+
+// NewReport starts a Report for a build using the given engine.
+func NewReport(engine string) *Report {
+	return &Report{Engine: engine}
+}
+
+// AddIteration appends a completed iteration's stats.
+func (r *Report) AddIteration(it IterationReport) {
+	r.Iterations = append(r.Iterations, it)
+}
+
+// CountLogIssues scans the TeX .log file at path and fills r.Warnings and
+// r.Errors, so a CI system can fail a build on new warnings without
+// reparsing the log itself.
+func (r *Report) CountLogIssues(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "! "):
+			r.Errors++
+		case strings.Contains(line, "Warning"):
+			r.Warnings++
+		}
+	}
+	return scanner.Err()
+}
+
+// Finish records the final PDF's path and size.
+func (r *Report) Finish(pdfPath string) {
+	r.PDFPath = pdfPath
+	if info, err := os.Stat(pdfPath); err == nil {
+		r.PDFSize = info.Size()
+	}
+}
+
+// Save writes r as JSON to path.
+func (r *Report) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,188 @@
+// This is free and unencumbered software released into the public domain.
+//
+// Anyone is free to copy, modify, publish, use, compile, sell, or
+// distribute this software, either in source code form or as a compiled
+// binary, for any purpose, commercial or non-commercial, and by any
+// means.
+//
+// In jurisdictions that recognize copyright laws, the author or authors
+// of this software dedicate any and all copyright interest in the
+// software to the public domain. We make this dedication for the benefit
+// of the public at large and to the detriment of our heirs and
+// successors. We intend this dedication to be an overt act of
+// relinquishment in perpetuity of all present and future rights to this
+// software under copyright law.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+// IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+// OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+// ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+// OTHER DEALINGS IN THE SOFTWARE.
+//
+// For more information, please refer to <http://unlicense.org>
+
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchedExt are the source extensions dtex always watches for, on top of
+// whatever the last compile's .fls recorder reported as an INPUT.
+var watchedExt = map[string]bool{
+	".tex": true,
+	".bib": true,
+	".cls": true,
+	".sty": true,
+}
+
+// ParseFls extracts every INPUT path recorded in a pdflatex -recorder .fls
+// file that lives under root, so callers can discover graphics, bib files
+// and \input'd fragments without the user having to configure them.
+func ParseFls(path, root string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inputs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		rest := strings.TrimPrefix(scanner.Text(), "INPUT ")
+		if rest == scanner.Text() {
+			continue
+		}
+		abs, err := filepath.Abs(rest)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, abs); err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		inputs = append(inputs, abs)
+	}
+	if err := scanner.Err(); err != nil {
+		return inputs, err
+	}
+	return inputs, nil
+}
+
+// collectWatchFiles walks root for files with a watchedExt extension and
+// adds whatever the last compile's .fls reports as an INPUT under root.
+func collectWatchFiles(root, tmpbase string) []string {
+	var files []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if watchedExt[filepath.Ext(path)] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	inputs, err := ParseFls(tmpbase+".fls", root)
+	if err != nil {
+		log.Println("Parse .fls:", err)
+	} else {
+		files = append(files, inputs...)
+	}
+	return files
+}
+
+// Watch rebuilds the document, reusing hashes so iterations stay fast,
+// whenever a relevant source file changes: anything under root with a
+// watchedExt extension, plus anything the last compile's .fls recorder
+// reported as an INPUT. It never returns.
+func Watch(root, file, tmpbase, tex string, args []string, cfg *Config, hashes *Hashes, cache string) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		Err("start watcher: %v\n", err)
+	}
+	defer w.Close()
+
+	addWatches := func() {
+		for _, f := range collectWatchFiles(root, tmpbase) {
+			if err := w.Add(f); err != nil {
+				log.Println("Watch", f, ":", err)
+			}
+		}
+	}
+	addWatches()
+	log.Println("Watching", root, "for changes")
+
+	// building/pending guard against a second rebuild starting while a
+	// multi-second compile loop is still in flight: debounce.Stop() is a
+	// no-op on a timer that already fired, so a change arriving mid-rebuild
+	// would otherwise start a second RunConvergence concurrently against
+	// the same *Hashes and a second pdflatex writing the same -output-directory.
+	var mu sync.Mutex
+	building, pending := false, false
+	rebuild := func() {
+		mu.Lock()
+		if building {
+			pending = true
+			mu.Unlock()
+			return
+		}
+		building = true
+		mu.Unlock()
+
+		for {
+			log.Println("Change detected, rebuilding")
+			// force: a watched .tex/.bib/.cls/.sty source changed, which
+			// Hashes (tracking only the output directory) can't see on
+			// its own, so hashes.Changed() would otherwise still read
+			// false.
+			RunConvergence(tex, args, tmpbase, cfg, hashes, nil, true)
+			if err := hashes.Save(cache); err != nil {
+				log.Println("Save hash cache:", err)
+			}
+			if err := os.Rename(tmpbase+".pdf", file+".pdf"); err != nil {
+				log.Println("Move resulting pdf into place:", err)
+			}
+			addWatches() // the .fls may have picked up new inputs
+
+			mu.Lock()
+			if !pending {
+				building = false
+				mu.Unlock()
+				return
+			}
+			pending = false
+			mu.Unlock()
+		}
+	}
+
+	var debounce *time.Timer
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if debounce != nil {
+				debounce.Stop()
+			}
+			debounce = time.AfterFunc(200*time.Millisecond, rebuild)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Println("Watch error:", err)
+		}
+	}
+}